@@ -34,6 +34,11 @@ type tsEvaluator struct {
 }
 
 func (e *tsEvaluator) eval() (bool, error) {
+	if e.q.root == nil {
+		// An empty query (e.g. one built from all-stopword input by
+		// PhraseToTSQuery or WebSearchToTSQuery) matches nothing.
+		return false, nil
+	}
 	return e.evalNode(e.q.root)
 }
 
@@ -47,6 +52,7 @@ func (e *tsEvaluator) evalNode(node *tsNode) (bool, error) {
 		if len(node.term.positions) > 0 && node.term.positions[0].weight == weightStar {
 			prefixMatch = true
 		}
+		weightMask := queryTermWeightMask(node.term)
 
 		// To evaluate a term, we search the vector for a match.
 		target := node.term.lexeme
@@ -56,9 +62,16 @@ func (e *tsEvaluator) evalNode(node *tsNode) (bool, error) {
 		if i < len(e.v) {
 			t := e.v[i]
 			if prefixMatch {
-				return strings.HasPrefix(t.lexeme, target), nil
+				if !strings.HasPrefix(t.lexeme, target) {
+					return false, nil
+				}
+			} else if t.lexeme != target {
+				return false, nil
+			}
+			if weightMask == 0 {
+				return true, nil
 			}
-			return t.lexeme == target, nil
+			return anyPositionMatchesWeight(t.positions, weightMask), nil
 		}
 		return false, nil
 	case and:
@@ -208,6 +221,7 @@ func (e *tsEvaluator) evalWithinFollowedBy(node *tsNode) (tsPositionSet, error)
 		if len(node.term.positions) > 0 && node.term.positions[0].weight == weightStar {
 			prefixMatch = true
 		}
+		weightMask := queryTermWeightMask(node.term)
 
 		// To evaluate a term, we search the vector for a match.
 		target := node.term.lexeme
@@ -225,7 +239,7 @@ func (e *tsEvaluator) evalWithinFollowedBy(node *tsNode) (tsPositionSet, error)
 				if !strings.HasPrefix(t.lexeme, target) {
 					break
 				}
-				ret = append(ret, t.positions...)
+				ret = append(ret, filterPositionsByWeight(t.positions, weightMask)...)
 			}
 			ret = sortAndUniqTSPositions(ret)
 			return tsPositionSet{positions: ret}, nil
@@ -233,8 +247,9 @@ func (e *tsEvaluator) evalWithinFollowedBy(node *tsNode) (tsPositionSet, error)
 			// No match.
 			return tsPositionSet{}, nil
 		}
-		// Return all of the positions at which the term is present.
-		return tsPositionSet{positions: e.v[i].positions}, nil
+		// Return all of the positions at which the term is present, filtered
+		// down to the query term's weight restriction, if any.
+		return tsPositionSet{positions: filterPositionsByWeight(e.v[i].positions, weightMask)}, nil
 	case or:
 		var lOffset, rOffset, width int
 
@@ -327,6 +342,44 @@ func (e *tsEvaluator) evalWithinFollowedBy(node *tsNode) (tsPositionSet, error)
 	return tsPositionSet{}, errors.AssertionFailedf("invalid operator %d", node.op)
 }
 
+// queryTermWeightMask returns the weight classes a query term is restricted
+// to, as in `cat:AB`, with the weightStar (prefix-match) bit masked out. A
+// zero result means the term has no weight restriction, and should match a
+// vector position of any weight.
+func queryTermWeightMask(term tsTerm) tsWeight {
+	if len(term.positions) == 0 {
+		return 0
+	}
+	return term.positions[0].weight &^ weightStar
+}
+
+// anyPositionMatchesWeight returns whether any of the given positions has a
+// weight in mask. It's used by evalNode, which only needs a boolean result.
+func anyPositionMatchesWeight(positions []tsPosition, mask tsWeight) bool {
+	for _, p := range positions {
+		if p.weight&mask != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// filterPositionsByWeight returns the subset of positions whose weight is in
+// mask. If mask is zero (no weight restriction was requested), positions is
+// returned unmodified.
+func filterPositionsByWeight(positions []tsPosition, mask tsWeight) []tsPosition {
+	if mask == 0 {
+		return positions
+	}
+	filtered := make([]tsPosition, 0, len(positions))
+	for _, p := range positions {
+		if p.weight&mask != 0 {
+			filtered = append(filtered, p)
+		}
+	}
+	return filtered
+}
+
 // sortAndUniqTSPositions sorts and uniquifies the input tsPosition list by
 // their position attributes.
 func sortAndUniqTSPositions(pos []tsPosition) []tsPosition {
@@ -0,0 +1,180 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package tsearch
+
+import "testing"
+
+// term builds a leaf query node for lexeme, optionally restricted to the
+// given weight classes (e.g. term("cat", weightA) for `cat:A`). Passing no
+// weights leaves the term unrestricted.
+func term(lexeme string, weights ...tsWeight) *tsNode {
+	var mask tsWeight
+	for _, w := range weights {
+		mask |= w
+	}
+	var positions []tsPosition
+	if mask != 0 {
+		positions = []tsPosition{{weight: mask}}
+	}
+	return &tsNode{op: invalid, term: tsTerm{lexeme: lexeme, positions: positions}}
+}
+
+// vec builds a TSVector from lexeme -> (position, weight) entries, for tests
+// that need precise control over where a lexeme occurs and at what weight.
+func vec(entries map[string][]tsPosition) TSVector {
+	v := make(TSVector, 0, len(entries))
+	for lexeme, positions := range entries {
+		v = append(v, tsTerm{lexeme: lexeme, positions: positions})
+	}
+	sortAndUniqTSVectorTerms(v)
+	return v
+}
+
+func sortAndUniqTSVectorTerms(v TSVector) {
+	for i := 1; i < len(v); i++ {
+		for j := i; j > 0 && v[j].lexeme < v[j-1].lexeme; j-- {
+			v[j], v[j-1] = v[j-1], v[j]
+		}
+	}
+}
+
+func TestEvalTSQuery(t *testing.T) {
+	testCases := []struct {
+		name  string
+		v     TSVector
+		q     *tsNode
+		match bool
+	}{
+		{
+			name:  "simple match",
+			v:     vec(map[string][]tsPosition{"cat": {{position: 1}}}),
+			q:     term("cat"),
+			match: true,
+		},
+		{
+			name:  "simple no match",
+			v:     vec(map[string][]tsPosition{"cat": {{position: 1}}}),
+			q:     term("dog"),
+			match: false,
+		},
+		{
+			name: "and requires both",
+			v:    vec(map[string][]tsPosition{"cat": {{position: 1}}}),
+			q:    &tsNode{op: and, l: term("cat"), r: term("dog")},
+		},
+		{
+			name:  "and with both present",
+			v:     vec(map[string][]tsPosition{"cat": {{position: 1}}, "dog": {{position: 2}}}),
+			q:     &tsNode{op: and, l: term("cat"), r: term("dog")},
+			match: true,
+		},
+		{
+			name:  "or with either present",
+			v:     vec(map[string][]tsPosition{"dog": {{position: 1}}}),
+			q:     &tsNode{op: or, l: term("cat"), r: term("dog")},
+			match: true,
+		},
+		{
+			name:  "not inverts",
+			v:     vec(map[string][]tsPosition{"dog": {{position: 1}}}),
+			q:     &tsNode{op: not, l: term("cat")},
+			match: true,
+		},
+		{
+			name:  "followed by at correct distance",
+			v:     vec(map[string][]tsPosition{"cat": {{position: 1}}, "dog": {{position: 2}}}),
+			q:     &tsNode{op: followedby, l: term("cat"), r: term("dog"), followedN: 1},
+			match: true,
+		},
+		{
+			name:  "followed by at wrong distance",
+			v:     vec(map[string][]tsPosition{"cat": {{position: 1}}, "dog": {{position: 5}}}),
+			q:     &tsNode{op: followedby, l: term("cat"), r: term("dog"), followedN: 1},
+			match: false,
+		},
+		{
+			name:  "weight restriction excludes non-matching class",
+			v:     vec(map[string][]tsPosition{"cat": {{position: 1, weight: weightB}}}),
+			q:     term("cat", weightA),
+			match: false,
+		},
+		{
+			name:  "weight restriction matches the requested class",
+			v:     vec(map[string][]tsPosition{"cat": {{position: 1, weight: weightA}}}),
+			q:     term("cat", weightA),
+			match: true,
+		},
+		{
+			name: "not combined with a weight restriction",
+			// cat only occurs at weight B, so cat:A doesn't match, so !cat:A does.
+			v:     vec(map[string][]tsPosition{"cat": {{position: 1, weight: weightB}}}),
+			q:     &tsNode{op: not, l: term("cat", weightA)},
+			match: true,
+		},
+		{
+			name: "and combined with a weight restriction on one side",
+			v: vec(map[string][]tsPosition{
+				"cat": {{position: 1, weight: weightA}},
+				"dog": {{position: 2, weight: weightB}},
+			}),
+			q:     &tsNode{op: and, l: term("cat", weightA), r: term("dog")},
+			match: true,
+		},
+		{
+			name: "followed by combined with a weight restriction",
+			v: vec(map[string][]tsPosition{
+				"cat": {{position: 1, weight: weightB}},
+				"dog": {{position: 2}},
+			}),
+			// cat only occurs at weight B, so cat:A <-> dog can't match here.
+			q:     &tsNode{op: followedby, l: term("cat", weightA), r: term("dog"), followedN: 1},
+			match: false,
+		},
+		{
+			name: "or combined with a weight restriction on one side",
+			// cat only occurs at weight B, so cat:A doesn't match, but dog
+			// (unrestricted) does, so cat:A | dog still matches.
+			v:     vec(map[string][]tsPosition{"cat": {{position: 1, weight: weightB}}, "dog": {{position: 2}}}),
+			q:     &tsNode{op: or, l: term("cat", weightA), r: term("dog")},
+			match: true,
+		},
+		{
+			name: "or combined with a weight restriction, neither side matching",
+			// cat only occurs at weight B, so cat:A doesn't match, and dog
+			// doesn't occur at all, so cat:A | dog doesn't match either.
+			v:     vec(map[string][]tsPosition{"cat": {{position: 1, weight: weightB}}}),
+			q:     &tsNode{op: or, l: term("cat", weightA), r: term("dog")},
+			match: false,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			match, err := EvalTSQuery(TSQuery{root: tc.q}, tc.v)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if match != tc.match {
+				t.Errorf("expected match=%v, got %v", tc.match, match)
+			}
+		})
+	}
+}
+
+func TestEvalTSQueryEmptyQuery(t *testing.T) {
+	v := vec(map[string][]tsPosition{"cat": {{position: 1}}})
+	match, err := EvalTSQuery(TSQuery{}, v)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if match {
+		t.Errorf("expected an empty query to match nothing")
+	}
+}
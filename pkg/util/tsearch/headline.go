@@ -0,0 +1,336 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package tsearch
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// HeadlineOptions controls the formatting of the result of Headline, mirroring
+// the options accepted by Postgres's ts_headline.
+type HeadlineOptions struct {
+	// StartSel and StopSel are inserted around every highlighted lexeme.
+	StartSel, StopSel string
+	// MaxWords and MinWords bound the length, in words, of each fragment.
+	MaxWords, MinWords int
+	// ShortWord is the maximum length of a word that's dropped from the start
+	// of a fragment when trimming it to size.
+	ShortWord int
+	// MaxFragments is the maximum number of fragments to return. Zero (or a
+	// negative value) is treated as 1: a single fragment built around the best
+	// cover, sized the same way as any other fragment.
+	MaxFragments int
+	// FragmentDelimiter separates multiple fragments in the output.
+	FragmentDelimiter string
+	// HighlightAll indicates that the entire document should be returned,
+	// with every match highlighted, rather than a set of fragments.
+	HighlightAll bool
+}
+
+// DefaultHeadlineOptions returns the options Postgres uses for ts_headline
+// when the caller doesn't override them.
+func DefaultHeadlineOptions() HeadlineOptions {
+	return HeadlineOptions{
+		StartSel:          "<b>",
+		StopSel:           "</b>",
+		MaxWords:          35,
+		MinWords:          15,
+		ShortWord:         3,
+		MaxFragments:      0,
+		FragmentDelimiter: " ... ",
+	}
+}
+
+// headlineWord is one word-like token scanned out of the original document,
+// with enough information to both match it against the query and slice the
+// original source text back out around it.
+type headlineWord struct {
+	// start and end are byte offsets of the raw token within the document.
+	start, end int
+	// lexeme is the normalized form of the token, or "" if the token isn't a
+	// word that participates in matching (e.g. punctuation, or a stopword).
+	lexeme string
+}
+
+// Headline implements the ts_headline family of built-ins. It returns an
+// excerpt of doc with the lexemes matched by q wrapped in opts.StartSel and
+// opts.StopSel. Unlike EvalTSQuery, which only sees normalized lexemes,
+// Headline re-tokenizes the original document so that it can slice out and
+// return the raw source text surrounding each match.
+func Headline(cfg Config, doc string, q TSQuery, opts HeadlineOptions) (string, error) {
+	words, err := tokenizeForHeadline(cfg, doc)
+	if err != nil {
+		return "", err
+	}
+
+	// Build a synthetic vector over the document's words, positioned by
+	// index into words, so we can reuse the same evalWithinFollowedBy
+	// machinery that EvalTSQuery uses against a real TSVector. Each leaf term
+	// outside a not is matched on its own, so a followed-by distance in the
+	// query doesn't restrict which words are highlighted or which covers are
+	// found.
+	v, wordForPosition := vectorFromHeadlineWords(words)
+	evaluator := tsEvaluator{v: v, q: q}
+	terms := collectLeafTerms(q.root, nil)
+	var covers []cover
+	if len(terms) > 0 {
+		covers, err = findTermCovers(evaluator, terms)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	// Every word matched by a leaf term is highlighted, whether or not it
+	// falls inside a cover.
+	matched := make(map[uint16]bool)
+	for _, t := range terms {
+		positions, err := evaluator.evalWithinFollowedBy(t)
+		if err != nil {
+			return "", err
+		}
+		for _, p := range positions.positions {
+			matched[p.position] = true
+		}
+	}
+
+	if opts.HighlightAll {
+		return renderHighlightAll(doc, words, matched, opts), nil
+	}
+	return renderFragments(doc, words, wordForPosition, covers, matched, opts), nil
+}
+
+// tokenizeForHeadline splits doc into word-like tokens (runs of letters and
+// digits, separated by everything else), recording each token's byte offsets
+// and, for tokens that survive text search normalization, its lexeme.
+func tokenizeForHeadline(cfg Config, doc string) ([]headlineWord, error) {
+	var words []headlineWord
+	runes := []rune(doc)
+	// byteOffsets[i] is the byte offset of runes[i] within doc.
+	byteOffsets := make([]int, len(runes)+1)
+	{
+		b := 0
+		for i, r := range runes {
+			byteOffsets[i] = b
+			b += len(string(r))
+		}
+		byteOffsets[len(runes)] = b
+	}
+
+	isWordRune := func(r rune) bool {
+		return unicode.IsLetter(r) || unicode.IsDigit(r)
+	}
+	i := 0
+	for i < len(runes) {
+		if !isWordRune(runes[i]) {
+			i++
+			continue
+		}
+		start := i
+		for i < len(runes) && isWordRune(runes[i]) {
+			i++
+		}
+		token := string(runes[start:i])
+		lexeme, err := lexemeForToken(cfg, token)
+		if err != nil {
+			return nil, err
+		}
+		words = append(words, headlineWord{
+			start:  byteOffsets[start],
+			end:    byteOffsets[i],
+			lexeme: lexeme,
+		})
+	}
+	return words, nil
+}
+
+// lexemeForToken normalizes a single token the same way the configuration
+// would normalize it while building a TSVector, returning "" if the token is
+// a stopword (or otherwise produces no lexeme).
+func lexemeForToken(cfg Config, token string) (string, error) {
+	v, err := ToTSVector(cfg, token)
+	if err != nil {
+		return "", err
+	}
+	if len(v) == 0 {
+		return "", nil
+	}
+	return v[0].lexeme, nil
+}
+
+// vectorFromHeadlineWords builds a synthetic TSVector over a document's
+// tokenized words, positioned by index into words, along with a lookup from
+// vector position back to the originating word.
+func vectorFromHeadlineWords(words []headlineWord) (TSVector, map[uint16]int) {
+	byLexeme := make(map[string][]tsPosition)
+	wordForPosition := make(map[uint16]int, len(words))
+	for i, w := range words {
+		if w.lexeme == "" || i > math.MaxUint16 {
+			continue
+		}
+		pos := uint16(i)
+		byLexeme[w.lexeme] = append(byLexeme[w.lexeme], tsPosition{position: pos})
+		wordForPosition[pos] = i
+	}
+	v := make(TSVector, 0, len(byLexeme))
+	for lexeme, positions := range byLexeme {
+		v = append(v, tsTerm{lexeme: lexeme, positions: positions})
+	}
+	sort.Slice(v, func(i, j int) bool { return v[i].lexeme < v[j].lexeme })
+	return v, wordForPosition
+}
+
+// renderFragments builds the fragmented (non-HighlightAll) headline: up to
+// opts.MaxFragments covers, each expanded to between opts.MinWords and
+// opts.MaxWords words of context, joined by opts.FragmentDelimiter. Covers
+// are considered best-first (most matched entries), and one is skipped if
+// its expanded word range overlaps a fragment already picked, so that two
+// closely-spaced matches don't produce overlapping (and therefore
+// duplicated) fragments. If no cover was found at all, it falls back to the
+// leading opts.MinWords words of the document, unhighlighted.
+func renderFragments(
+	doc string,
+	words []headlineWord,
+	wordForPosition map[uint16]int,
+	covers []cover,
+	matched map[uint16]bool,
+	opts HeadlineOptions,
+) string {
+	maxFragments := opts.MaxFragments
+	if maxFragments <= 0 {
+		maxFragments = 1
+	}
+	sort.Slice(covers, func(i, j int) bool {
+		return len(covers[i].entries) > len(covers[j].entries)
+	})
+	var picked [][2]int
+	for _, c := range covers {
+		if len(picked) >= maxFragments {
+			break
+		}
+		startWord := wordForPosition[c.start]
+		endWord := wordForPosition[c.end]
+		rng := expandRange(words, startWord, endWord, opts)
+		if rangeOverlapsAny(picked, rng) {
+			continue
+		}
+		picked = append(picked, rng)
+	}
+	if len(picked) == 0 {
+		return leadingWords(doc, words, opts)
+	}
+	// Fragments are shown in document order, not score order.
+	sort.Slice(picked, func(i, j int) bool { return picked[i][0] < picked[j][0] })
+
+	fragments := make([]string, len(picked))
+	for i, rng := range picked {
+		fragments[i] = renderWordRange(doc, words, rng, matched, opts)
+	}
+	return strings.Join(fragments, opts.FragmentDelimiter)
+}
+
+// rangeOverlapsAny returns whether the word range rng overlaps any of the
+// ranges in picked, used by renderFragments to keep selected fragments from
+// overlapping when two matches' expanded ranges would otherwise intersect.
+func rangeOverlapsAny(picked [][2]int, rng [2]int) bool {
+	for _, p := range picked {
+		if rng[0] <= p[1] && p[0] <= rng[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// renderHighlightAll returns the entire document with every matched word
+// wrapped in StartSel/StopSel, falling back to the leading MinWords words,
+// unhighlighted, if there were no matches at all.
+func renderHighlightAll(doc string, words []headlineWord, matched map[uint16]bool, opts HeadlineOptions) string {
+	if len(matched) == 0 {
+		return leadingWords(doc, words, opts)
+	}
+	// Keep any text before the first word and after the last one, so that the
+	// whole document is returned.
+	return doc[:words[0].start] +
+		renderWordRange(doc, words, [2]int{0, len(words) - 1}, matched, opts) +
+		doc[words[len(words)-1].end:]
+}
+
+// leadingWords returns the source text spanning the first opts.MinWords
+// words of the document (or the whole document, if it's shorter).
+func leadingWords(doc string, words []headlineWord, opts HeadlineOptions) string {
+	if len(words) == 0 {
+		return doc
+	}
+	last := opts.MinWords - 1
+	if last >= len(words) {
+		last = len(words) - 1
+	}
+	if last < 0 {
+		last = 0
+	}
+	return doc[words[0].start:words[last].end]
+}
+
+// expandRange grows [startWord, endWord] with surrounding context until it
+// spans at least opts.MinWords words, capped at opts.MaxWords, then trims any
+// leading run of words no longer than opts.ShortWord so fragments don't start
+// mid-clause on a tiny word.
+func expandRange(words []headlineWord, startWord, endWord int, opts HeadlineOptions) [2]int {
+	minWords, maxWords := opts.MinWords, opts.MaxWords
+	if maxWords <= 0 {
+		maxWords = len(words)
+	}
+	for endWord-startWord+1 < minWords && (startWord > 0 || endWord < len(words)-1) {
+		if startWord > 0 {
+			startWord--
+		}
+		if endWord-startWord+1 >= minWords {
+			break
+		}
+		if endWord < len(words)-1 {
+			endWord++
+		}
+	}
+	for endWord-startWord+1 > maxWords {
+		if endWord > startWord {
+			endWord--
+		} else {
+			break
+		}
+	}
+	for startWord < endWord && len(words[startWord].lexeme) <= opts.ShortWord {
+		startWord++
+	}
+	return [2]int{startWord, endWord}
+}
+
+// renderWordRange slices the document text spanning words[rng[0]:rng[1]+1],
+// wrapping every matched word in StartSel/StopSel.
+func renderWordRange(doc string, words []headlineWord, rng [2]int, matched map[uint16]bool, opts HeadlineOptions) string {
+	start, end := rng[0], rng[1]
+	var sb strings.Builder
+	cursor := words[start].start
+	for i := start; i <= end; i++ {
+		w := words[i]
+		sb.WriteString(doc[cursor:w.start])
+		if matched[uint16(i)] {
+			sb.WriteString(opts.StartSel)
+			sb.WriteString(doc[w.start:w.end])
+			sb.WriteString(opts.StopSel)
+		} else {
+			sb.WriteString(doc[w.start:w.end])
+		}
+		cursor = w.end
+	}
+	return sb.String()
+}
@@ -0,0 +1,78 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package tsearch
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHeadline(t *testing.T) {
+	opts := DefaultHeadlineOptions()
+	opts.MinWords = 1
+	opts.MaxWords = 3
+
+	headline, err := Headline(Config{}, "the cat sat on the mat", TSQuery{root: term("cat")}, opts)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(headline, "<b>cat</b>") {
+		t.Errorf("expected the matched word to be wrapped in StartSel/StopSel, got %q", headline)
+	}
+}
+
+func TestHeadlineNoMatch(t *testing.T) {
+	opts := DefaultHeadlineOptions()
+	opts.MinWords = 2
+
+	headline, err := Headline(Config{}, "the cat sat on the mat", TSQuery{root: term("dog")}, opts)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.Contains(headline, opts.StartSel) {
+		t.Errorf("expected no highlighting when the query doesn't match, got %q", headline)
+	}
+}
+
+func TestHeadlineNonOverlappingFragments(t *testing.T) {
+	opts := DefaultHeadlineOptions()
+	opts.MaxFragments = 2
+
+	// Two "cat" matches close enough together that, with the default
+	// MinWords, their expanded word ranges overlap - the second match
+	// shouldn't produce a fragment that duplicates text already covered by
+	// the first.
+	doc := "cat alpha beta gamma delta epsilon zeta eta theta iota " +
+		"cat kappa lambda mu nu xi omicron pi rho sigma"
+	headline, err := Headline(Config{}, doc, TSQuery{root: term("cat")}, opts)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n := strings.Count(headline, "<b>cat</b>"); n != 1 {
+		t.Errorf("expected exactly one highlighted match from overlapping fragments, got %d in %q", n, headline)
+	}
+}
+
+func TestHeadlineHighlightAll(t *testing.T) {
+	opts := DefaultHeadlineOptions()
+	opts.HighlightAll = true
+
+	headline, err := Headline(Config{}, "the cat sat on the mat", TSQuery{root: term("cat")}, opts)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(headline, "<b>cat</b>") {
+		t.Errorf("expected the matched word to be wrapped in StartSel/StopSel, got %q", headline)
+	}
+	if !strings.HasPrefix(headline, "the ") {
+		t.Errorf("expected HighlightAll to return the entire document, got %q", headline)
+	}
+}
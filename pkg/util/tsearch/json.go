@@ -0,0 +1,164 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package tsearch
+
+import (
+	"math"
+	"sort"
+
+	"github.com/cockroachdb/cockroach/pkg/util/json"
+)
+
+// jsonLeafGap is the position gap inserted between the lexemes of two
+// consecutive string leaves extracted from a JSON document, so that a
+// followed-by operator (<->, <N>) doesn't treat two unrelated strings in the
+// document as adjacent, mirroring how Postgres's to_tsvector(jsonb) keeps
+// array elements and object values separate.
+//
+// followedN and tsPosition.position are both uint16, so no finite gap can
+// rule out every representable distance without also pushing a later leaf's
+// own positions past math.MaxUint16 and dropping it outright (see the
+// overflow guard in addLeaf below) - there's no way to keep leaves
+// indexable and make every <N> unbridgeable at the same time. This is
+// chosen large enough to isolate leaves from any distance a real query would
+// specify, at the cost of leaving a pathologically large explicit <N> (on
+// the order of jsonLeafGap itself) able to bridge two adjacent leaves.
+const jsonLeafGap = math.MaxUint16 / 2
+
+// TSVectorFromJSON walks a JSON or JSONB value, extracting every string leaf
+// - both object values and array elements - tokenizing each with cfg the same
+// way ToTSVector would, and merging the resulting lexemes into a single
+// TSVector. Positions are advanced across leaves by jsonLeafGap so that
+// followed-by queries don't spuriously match across string boundaries. This
+// mirrors Postgres's to_tsvector(config, jsonb).
+func TSVectorFromJSON(cfg Config, j json.JSON) (TSVector, error) {
+	var b jsonVectorBuilder
+	if err := b.addLeaves(cfg, j); err != nil {
+		return nil, err
+	}
+	return b.vector(), nil
+}
+
+// TSMatchJSON evaluates q against the text-search vector extracted from j,
+// mirroring Postgres's `jsonb @@ tsquery`.
+func TSMatchJSON(cfg Config, j json.JSON, q TSQuery) (bool, error) {
+	v, err := TSVectorFromJSON(cfg, j)
+	if err != nil {
+		return false, err
+	}
+	return EvalTSQuery(q, v)
+}
+
+// jsonVectorBuilder accumulates the lexemes extracted from a JSON document's
+// string leaves into a single TSVector, tracking the position offset at
+// which the next leaf's lexemes should start.
+type jsonVectorBuilder struct {
+	byLexeme map[string][]tsPosition
+	offset   int
+}
+
+// addLeaves recursively walks j, feeding every string leaf it finds to
+// addLeaf. Numbers, booleans, and null contribute no lexemes, matching
+// Postgres's behavior of only indexing JSON strings.
+func (b *jsonVectorBuilder) addLeaves(cfg Config, j json.JSON) error {
+	switch j.Type() {
+	case json.StringJSONType:
+		text, err := j.AsText()
+		if err != nil {
+			return err
+		}
+		if text == nil {
+			return nil
+		}
+		return b.addLeaf(cfg, *text)
+	case json.ObjectJSONType:
+		it, err := j.ObjectIter()
+		if err != nil {
+			return err
+		}
+		if it == nil {
+			return nil
+		}
+		for it.Next() {
+			if err := b.addLeaves(cfg, it.Value()); err != nil {
+				return err
+			}
+		}
+		return nil
+	case json.ArrayJSONType:
+		n, err := j.Len()
+		if err != nil {
+			return err
+		}
+		for i := 0; i < n; i++ {
+			elem, err := j.FetchValIdx(i)
+			if err != nil {
+				return err
+			}
+			if err := b.addLeaves(cfg, elem); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return nil
+	}
+}
+
+// addLeaf tokenizes a single string leaf and merges its lexemes into the
+// builder, shifting their positions by the builder's current offset and then
+// advancing the offset past this leaf (plus jsonLeafGap) for the next one.
+func (b *jsonVectorBuilder) addLeaf(cfg Config, text string) error {
+	v, err := ToTSVector(cfg, text)
+	if err != nil {
+		return err
+	}
+	if b.byLexeme == nil {
+		b.byLexeme = make(map[string][]tsPosition)
+	}
+	// nextOffset always advances by at least jsonLeafGap from the current
+	// offset, even if this leaf contributes no lexemes at all (e.g. it's
+	// entirely stopwords or punctuation) - otherwise a content-free leaf
+	// would reset the offset backwards and a later leaf could reuse an
+	// earlier leaf's positions, letting a followed-by query match across
+	// the two as if they were adjacent in one string.
+	nextOffset := b.offset + jsonLeafGap
+	for _, term := range v {
+		for _, p := range term.positions {
+			shifted := int(p.position) + b.offset
+			if shifted > math.MaxUint16 {
+				// Drop positions beyond what a uint16 can represent, the same
+				// way TSVector positions are capped elsewhere in this
+				// package.
+				continue
+			}
+			if shifted+jsonLeafGap > nextOffset {
+				nextOffset = shifted + jsonLeafGap
+			}
+			shiftedPos := p
+			shiftedPos.position = uint16(shifted)
+			b.byLexeme[term.lexeme] = append(b.byLexeme[term.lexeme], shiftedPos)
+		}
+	}
+	b.offset = nextOffset
+	return nil
+}
+
+// vector produces the final, sorted TSVector from the builder's accumulated
+// lexemes.
+func (b *jsonVectorBuilder) vector() TSVector {
+	v := make(TSVector, 0, len(b.byLexeme))
+	for lexeme, positions := range b.byLexeme {
+		v = append(v, tsTerm{lexeme: lexeme, positions: sortAndUniqTSPositions(positions)})
+	}
+	sort.Slice(v, func(i, j int) bool { return v[i].lexeme < v[j].lexeme })
+	return v
+}
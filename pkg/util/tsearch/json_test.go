@@ -0,0 +1,95 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package tsearch
+
+import (
+	"testing"
+
+	"github.com/cockroachdb/cockroach/pkg/util/json"
+)
+
+func mustParseJSON(t *testing.T, s string) json.JSON {
+	t.Helper()
+	j, err := json.ParseJSON(s)
+	if err != nil {
+		t.Fatalf("unexpected error parsing %q: %v", s, err)
+	}
+	return j
+}
+
+func TestTSVectorFromJSON(t *testing.T) {
+	testCases := []struct {
+		name  string
+		doc   string
+		q     *tsNode
+		match bool
+	}{
+		{
+			name:  "object value string leaves are indexed",
+			doc:   `{"a": "cat", "b": "dog"}`,
+			q:     &tsNode{op: and, l: term("cat"), r: term("dog")},
+			match: true,
+		},
+		{
+			name:  "array element string leaves are indexed",
+			doc:   `["cat", "dog"]`,
+			q:     &tsNode{op: and, l: term("cat"), r: term("dog")},
+			match: true,
+		},
+		{
+			name:  "numbers and booleans contribute no lexemes",
+			doc:   `{"a": 1, "b": true, "c": null}`,
+			q:     term("cat"),
+			match: false,
+		},
+		{
+			name: "distinct leaves aren't treated as adjacent",
+			doc:  `{"a": "cat", "b": "dog"}`,
+			// cat and dog are in separate JSON leaves, so they shouldn't be
+			// directly adjacent even though they're the first lexeme of each
+			// leaf's vector.
+			q:     &tsNode{op: followedby, l: term("cat"), r: term("dog"), followedN: 1},
+			match: false,
+		},
+		{
+			name: "a large explicit distance still doesn't bridge leaves",
+			doc:  `{"a": "cat", "b": "dog"}`,
+			// A naively small jsonLeafGap would let a large enough explicit
+			// <N> bridge the two leaves; jsonLeafGap is chosen to stay out of
+			// reach of any distance a real query would specify.
+			q:     &tsNode{op: followedby, l: term("cat"), r: term("dog"), followedN: 1000},
+			match: false,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			j := mustParseJSON(t, tc.doc)
+			v, err := TSVectorFromJSON(Config{}, j)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if match := evalQuery(t, TSQuery{root: tc.q}, v); match != tc.match {
+				t.Errorf("expected match=%v, got %v", tc.match, match)
+			}
+		})
+	}
+}
+
+func TestTSMatchJSON(t *testing.T) {
+	j := mustParseJSON(t, `{"a": "cat"}`)
+	match, err := TSMatchJSON(Config{}, j, TSQuery{root: term("cat")})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !match {
+		t.Errorf("expected TSMatchJSON to find the lexeme nested under the object's value")
+	}
+}
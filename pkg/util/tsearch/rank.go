@@ -0,0 +1,351 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package tsearch
+
+import (
+	"math"
+	"sort"
+
+	"github.com/cockroachdb/errors"
+)
+
+// Normalization bits, mirroring Postgres's ts_rank/ts_rank_cd normalization
+// argument. They can be OR'd together, in which case each requested
+// normalization is applied to the raw rank in turn.
+const (
+	// RankNormLogLength divides the rank by 1 + the log of the document
+	// length.
+	RankNormLogLength = 1 << iota
+	// RankNormLength divides the rank by the document length.
+	RankNormLength
+	// RankNormMeanHarmonicDistance divides the rank by the mean harmonic
+	// distance of the covers found during cover density ranking, as computed
+	// by meanHarmonicDistance. It has no effect on TSRank, which has no notion
+	// of covers.
+	RankNormMeanHarmonicDistance
+	// RankNormUniqueWords divides the rank by the number of unique words in
+	// the document.
+	RankNormUniqueWords
+	// RankNormLogUniqueWords divides the rank by 1 + the log of the number of
+	// unique words in the document.
+	RankNormLogUniqueWords
+	// RankNormRankOverSum divides the rank by itself + 1, so that the result
+	// is guaranteed to fall into the range [0, 1).
+	RankNormRankOverSum
+)
+
+// DefaultRankWeights returns the default per-weight-class multipliers used by
+// ts_rank and ts_rank_cd when the caller doesn't supply its own, in
+// {D, C, B, A} order to match Postgres.
+func DefaultRankWeights() [4]float32 {
+	return [4]float32{0.1, 0.2, 0.4, 1.0}
+}
+
+// weightIndex maps a tsPosition's weight to an index into a [4]float32
+// weights array ordered {D, C, B, A}, matching Postgres. Positions with no
+// weight label default to D, the lowest-priority class.
+func weightIndex(w tsWeight) int {
+	switch w &^ weightStar {
+	case weightA:
+		return 3
+	case weightB:
+		return 2
+	case weightC:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// TSRank implements the ts_rank family of built-ins: it scores how well the
+// vector v matches the query q, weighting matches at each position by the
+// weight class array (in {D, C, B, A} order, matching Postgres) and applying
+// the requested normalization bitmask to the raw score.
+func TSRank(weights [4]float32, v TSVector, q TSQuery, normalization int) (float32, error) {
+	if err := validateNormalization(normalization); err != nil {
+		return 0, err
+	}
+	if q.root == nil {
+		// An empty query (e.g. one built from all-stopword input by
+		// PhraseToTSQuery or WebSearchToTSQuery) ranks as 0.
+		return 0, nil
+	}
+	evaluator := tsEvaluator{v: v, q: q}
+	matchWeights, err := evaluator.collectRankWeights(q.root, weights)
+	if err != nil {
+		return 0, err
+	}
+	if len(matchWeights) == 0 {
+		return 0, nil
+	}
+	rank := combineWeights(matchWeights)
+	return normalizeRank(rank, normalization, v, 0), nil
+}
+
+// collectRankWeights walks the query tree to gather the weight-class
+// contribution of every matching position, for use by TSRank. Unlike
+// evalWithinFollowedBy, it doesn't align and/or operands by vector position -
+// it's only concerned with which positions match at all, so each side of an
+// and/or is scored independently and their contributions are pooled. A not
+// contributes nothing, since a cover can't be defined by the absence of a
+// word. followedby still needs positional alignment, so it defers to
+// evalWithinFollowedBy, as does a bare leaf term.
+func (e *tsEvaluator) collectRankWeights(node *tsNode, weights [4]float32) ([]float32, error) {
+	switch node.op {
+	case not:
+		return nil, nil
+	case and, or:
+		l, err := e.collectRankWeights(node.l, weights)
+		if err != nil {
+			return nil, err
+		}
+		r, err := e.collectRankWeights(node.r, weights)
+		if err != nil {
+			return nil, err
+		}
+		return append(l, r...), nil
+	default:
+		// A leaf term or a followedby subexpression: both are scored by the
+		// positions at which they match, which evalWithinFollowedBy already
+		// computes correctly on its own (with no enclosing alignment needed).
+		positions, err := e.evalWithinFollowedBy(node)
+		if err != nil {
+			return nil, err
+		}
+		matchWeights := make([]float32, len(positions.positions))
+		for i, p := range positions.positions {
+			matchWeights[i] = weights[weightIndex(p.weight)]
+		}
+		return matchWeights, nil
+	}
+}
+
+// TSRankCD implements the ts_rank_cd family of built-ins: like TSRank, but
+// using cover density ranking, which rewards matches where the query's
+// lexemes appear close together in the document. It locates every cover - a
+// minimal span of vector positions that contains a match for every distinct
+// lexeme referenced by the query - and scores each cover by the summed weights
+// of its matches divided by its length, then combines the covers' scores by
+// summing their squares, the same way TSRank combines individual matches.
+func TSRankCD(weights [4]float32, v TSVector, q TSQuery, normalization int) (float32, error) {
+	if err := validateNormalization(normalization); err != nil {
+		return 0, err
+	}
+	covers, err := findCovers(v, q)
+	if err != nil {
+		return 0, err
+	}
+	if len(covers) == 0 {
+		return 0, nil
+	}
+	coverWeights := make([]float32, len(covers))
+	for i, c := range covers {
+		coverWeights[i] = c.weight(weights)
+	}
+	rank := combineWeights(coverWeights)
+	return normalizeRank(rank, normalization, v, meanHarmonicDistance(covers)), nil
+}
+
+// combineWeights combines a set of per-match (or per-cover) weights into a
+// single raw rank. Matches with a higher weight contribute more, and, as in
+// Postgres, contributions diminish for terms with a weight below 1 by
+// squaring them.
+func combineWeights(weights []float32) float32 {
+	var res float32
+	for _, w := range weights {
+		res += w * w
+	}
+	return res
+}
+
+// wordEntry associates a matching vector position with the weight class it
+// was found at, for use while scoring covers.
+type wordEntry struct {
+	position uint16
+	term     int
+	weight   tsWeight
+}
+
+// cover represents a minimal window of vector positions that contains at
+// least one match for every distinct leaf term referenced by the query.
+type cover struct {
+	start, end uint16
+	entries    []wordEntry
+}
+
+// weight scores a cover the way Postgres's cover density ranking does: the
+// sum of the weights of the matches it contains, divided by its length in
+// lexeme positions, so tighter covers of highly-weighted terms score higher.
+func (c cover) weight(weights [4]float32) float32 {
+	length := float32(c.end-c.start) + 1
+	var sum float32
+	for _, ent := range c.entries {
+		sum += weights[weightIndex(ent.weight)]
+	}
+	return sum / length
+}
+
+// findCovers locates every minimal cover of the query's leaf terms within the
+// vector, using the same followed-by position machinery that evaluates
+// proximity queries to find each term's matching positions.
+func findCovers(v TSVector, q TSQuery) ([]cover, error) {
+	evaluator := tsEvaluator{v: v, q: q}
+	terms := collectLeafTerms(q.root, nil)
+	if len(terms) == 0 {
+		return nil, nil
+	}
+	return findTermCovers(evaluator, terms)
+}
+
+// findTermCovers is the shared core of findCovers: given an evaluator and its
+// query's leaf terms, it locates every minimal cover - a span of positions
+// containing at least one match for every distinct term - by merging each
+// term's matching positions into a single timeline and sliding a window
+// across it. It's factored out so that other consumers of the followed-by
+// position machinery, like Headline, can reuse the same cover search against
+// their own synthetic vectors.
+func findTermCovers(evaluator tsEvaluator, terms []*tsNode) ([]cover, error) {
+	var hits []wordEntry
+	for i, term := range terms {
+		positions, err := evaluator.evalWithinFollowedBy(term)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range positions.positions {
+			hits = append(hits, wordEntry{position: p.position, term: i, weight: p.weight})
+		}
+	}
+	if len(hits) == 0 {
+		return nil, nil
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		return hits[i].position < hits[j].position
+	})
+
+	// Slide a window over the merged, sorted hits, looking for the minimal
+	// span ending at each position that still contains a hit for every
+	// distinct term.
+	var covers []cover
+	counts := make([]int, len(terms))
+	distinct := 0
+	left := 0
+	for right := 0; right < len(hits); right++ {
+		if counts[hits[right].term] == 0 {
+			distinct++
+		}
+		counts[hits[right].term]++
+		for distinct == len(terms) {
+			// The window is only minimal once hits[left] is the last hit for its
+			// term within it; before that, dropping hits[left] keeps it a cover.
+			if counts[hits[left].term] == 1 {
+				entries := append([]wordEntry(nil), hits[left:right+1]...)
+				covers = append(covers, cover{
+					start:   hits[left].position,
+					end:     hits[right].position,
+					entries: entries,
+				})
+			}
+			counts[hits[left].term]--
+			if counts[hits[left].term] == 0 {
+				distinct--
+			}
+			left++
+		}
+	}
+	return covers, nil
+}
+
+// collectLeafTerms walks the query tree, gathering every leaf (term) node.
+// Terms under a `not` are excluded, since a cover can't be defined by the
+// absence of a word.
+func collectLeafTerms(node *tsNode, terms []*tsNode) []*tsNode {
+	if node == nil {
+		return terms
+	}
+	switch node.op {
+	case invalid:
+		return append(terms, node)
+	case not:
+		return terms
+	default:
+		terms = collectLeafTerms(node.l, terms)
+		terms = collectLeafTerms(node.r, terms)
+		return terms
+	}
+}
+
+// meanHarmonicDistance computes the mean harmonic distance across a set of
+// covers, for use by the RankNormMeanHarmonicDistance normalization. The
+// harmonic distance of a cover with n matches spanning a length of len is
+// n / len; we average those across all covers.
+func meanHarmonicDistance(covers []cover) float32 {
+	if len(covers) == 0 {
+		return 0
+	}
+	var sum float32
+	for _, c := range covers {
+		length := float32(c.end-c.start) + 1
+		sum += float32(len(c.entries)) / length
+	}
+	return sum / float32(len(covers))
+}
+
+// normalizeRank applies the requested normalization bits to a raw rank,
+// mirroring Postgres's calc_rank normalization handling. meanDistance is only
+// used by RankNormMeanHarmonicDistance, and is ignored (and may be zero) for
+// callers that don't have one to offer.
+func normalizeRank(rank float32, normalization int, v TSVector, meanDistance float32) float32 {
+	if normalization&RankNormLogLength != 0 {
+		rank /= float32(math.Log(float64(docLength(v)))) + 1
+	}
+	if normalization&RankNormLength != 0 {
+		if l := float32(docLength(v)); l > 0 {
+			rank /= l
+		}
+	}
+	if normalization&RankNormMeanHarmonicDistance != 0 && meanDistance > 0 {
+		rank /= meanDistance
+	}
+	if normalization&RankNormUniqueWords != 0 {
+		if u := float32(len(v)); u > 0 {
+			rank /= u
+		}
+	}
+	if normalization&RankNormLogUniqueWords != 0 {
+		rank /= float32(math.Log(float64(len(v)))) + 1
+	}
+	if normalization&RankNormRankOverSum != 0 {
+		rank /= rank + 1
+	}
+	return rank
+}
+
+// docLength returns the number of lexeme positions in the vector, used as the
+// "document length" for length-based rank normalization.
+func docLength(v TSVector) int {
+	var n int
+	for _, t := range v {
+		n += len(t.positions)
+	}
+	return n
+}
+
+// validateNormalization rejects normalization bitmasks that reference an
+// unknown normalization method, matching Postgres's behavior of erroring out
+// on an out-of-range ts_rank normalization argument.
+func validateNormalization(normalization int) error {
+	const allBits = RankNormLogLength | RankNormLength | RankNormMeanHarmonicDistance |
+		RankNormUniqueWords | RankNormLogUniqueWords | RankNormRankOverSum
+	if normalization&^allBits != 0 {
+		return errors.Newf("invalid rank normalization method: %d", normalization)
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package tsearch
+
+import "testing"
+
+func TestTSRank(t *testing.T) {
+	weights := DefaultRankWeights()
+	testCases := []struct {
+		name     string
+		v        TSVector
+		q        *tsNode
+		wantZero bool
+	}{
+		{
+			name: "and query with lexemes at unrelated positions still ranks",
+			v: vec(map[string][]tsPosition{
+				"cat": {{position: 1}},
+				"dog": {{position: 5}},
+			}),
+			q: &tsNode{op: and, l: term("cat"), r: term("dog")},
+		},
+		{
+			name: "or query ranks on whichever side matches",
+			v:    vec(map[string][]tsPosition{"dog": {{position: 5}}}),
+			q:    &tsNode{op: or, l: term("cat"), r: term("dog")},
+		},
+		{
+			name:     "not query contributes nothing",
+			v:        vec(map[string][]tsPosition{"cat": {{position: 1}}}),
+			q:        &tsNode{op: not, l: term("cat")},
+			wantZero: true,
+		},
+		{
+			name:     "no match at all ranks zero",
+			v:        vec(map[string][]tsPosition{"dog": {{position: 1}}}),
+			q:        term("cat"),
+			wantZero: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rank, err := TSRank(weights, tc.v, TSQuery{root: tc.q}, 0)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tc.wantZero && rank != 0 {
+				t.Errorf("expected a zero rank, got %v", rank)
+			}
+			if !tc.wantZero && rank == 0 {
+				t.Errorf("expected a nonzero rank, got 0")
+			}
+		})
+	}
+}
+
+// TestTSRankEmptyQuery verifies that TSRank handles a nil query root (as
+// produced by PhraseToTSQuery or WebSearchToTSQuery on all-stopword input)
+// without panicking.
+func TestTSRankEmptyQuery(t *testing.T) {
+	v := vec(map[string][]tsPosition{"cat": {{position: 1}}})
+	rank, err := TSRank(DefaultRankWeights(), v, TSQuery{}, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if rank != 0 {
+		t.Errorf("expected a zero rank for an empty query, got %v", rank)
+	}
+}
+
+func TestTSRankWeightClasses(t *testing.T) {
+	weights := DefaultRankWeights()
+	aVec := vec(map[string][]tsPosition{"cat": {{position: 1, weight: weightA}}})
+	dVec := vec(map[string][]tsPosition{"cat": {{position: 1}}})
+
+	aRank, err := TSRank(weights, aVec, TSQuery{root: term("cat")}, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	dRank, err := TSRank(weights, dVec, TSQuery{root: term("cat")}, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if aRank <= dRank {
+		t.Errorf("expected a weight-A match (%v) to rank above a weight-D match (%v)", aRank, dRank)
+	}
+}
+
+func TestTSRankCD(t *testing.T) {
+	weights := DefaultRankWeights()
+	q := TSQuery{root: &tsNode{op: and, l: term("cat"), r: term("dog")}}
+
+	tight := vec(map[string][]tsPosition{
+		"cat": {{position: 1}},
+		"dog": {{position: 2}},
+	})
+	spread := vec(map[string][]tsPosition{
+		"cat": {{position: 1}},
+		"dog": {{position: 20}},
+	})
+
+	tightRank, err := TSRankCD(weights, tight, q, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	spreadRank, err := TSRankCD(weights, spread, q, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if tightRank <= spreadRank {
+		t.Errorf("expected a tighter cover (%v) to outrank a spread-out one (%v)", tightRank, spreadRank)
+	}
+}
+
+func TestValidateNormalization(t *testing.T) {
+	if err := validateNormalization(RankNormLength | RankNormUniqueWords); err != nil {
+		t.Errorf("unexpected error for a valid normalization mask: %v", err)
+	}
+	if err := validateNormalization(1 << 20); err == nil {
+		t.Errorf("expected an error for an out-of-range normalization mask")
+	}
+}
@@ -0,0 +1,199 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package tsearch
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// PhraseToTSQuery implements phraseto_tsquery: it tokenizes and normalizes
+// text the same way ToTSVector does, then joins every consecutive lexeme with
+// a followed-by operator. Adjacent lexemes are joined with <-> (distance 1);
+// lexemes separated by one or more elided stopwords are joined with <N>,
+// where N is the number of token positions between them, so the resulting
+// query still requires the original word order without requiring the
+// stopwords themselves to be present in the vector.
+//
+// Like Postgres, malformed or entirely-stopword input never produces an
+// error - it just produces an empty query, whose root is nil and which
+// therefore matches nothing.
+func PhraseToTSQuery(cfg Config, text string) (TSQuery, error) {
+	v, err := ToTSVector(cfg, text)
+	if err != nil {
+		return TSQuery{}, err
+	}
+	entries := flattenVectorPositions(v)
+	if len(entries) == 0 {
+		return TSQuery{}, nil
+	}
+	root := &tsNode{op: invalid, term: tsTerm{lexeme: entries[0].lexeme}}
+	for i := 1; i < len(entries); i++ {
+		gap := int(entries[i].position) - int(entries[i-1].position)
+		if gap < 1 {
+			gap = 1
+		}
+		right := &tsNode{op: invalid, term: tsTerm{lexeme: entries[i].lexeme}}
+		root = &tsNode{op: followedby, l: root, r: right, followedN: uint16(gap)}
+	}
+	return TSQuery{root: root}, nil
+}
+
+// WebSearchToTSQuery implements websearch_to_tsquery, which turns Google-style
+// free-form search syntax into a TSQuery:
+//   - unquoted words are AND-ed together (&)
+//   - "quoted phrases" are turned into a followed-by chain, just as
+//     PhraseToTSQuery would build for that phrase, and AND-ed with the rest
+//   - the word "or" between two terms ORs them together (|) instead of
+//     AND-ing them
+//   - a leading "-" on a word or "quoted phrase" negates it (!)
+//
+// As in Postgres, this never returns a parse error for malformed user input;
+// fragments that don't produce a usable term (e.g. a lone stopword) are
+// silently dropped.
+func WebSearchToTSQuery(cfg Config, text string) (TSQuery, error) {
+	var operands []*tsNode
+	pendingOr := false
+	for _, tok := range tokenizeWebSearch(text) {
+		if tok.isOr {
+			pendingOr = true
+			continue
+		}
+		node, err := webSearchTermNode(cfg, tok)
+		if err != nil {
+			// Never fail on malformed user text; just drop the fragment.
+			continue
+		}
+		if node == nil {
+			continue
+		}
+		if tok.negated {
+			node = &tsNode{op: not, l: node}
+		}
+		if pendingOr && len(operands) > 0 {
+			operands[len(operands)-1] = &tsNode{op: or, l: operands[len(operands)-1], r: node}
+		} else {
+			operands = append(operands, node)
+		}
+		pendingOr = false
+	}
+	if len(operands) == 0 {
+		return TSQuery{}, nil
+	}
+	root := operands[0]
+	for _, n := range operands[1:] {
+		root = &tsNode{op: and, l: root, r: n}
+	}
+	return TSQuery{root: root}, nil
+}
+
+// webSearchTermNode turns a single websearch token into a query node,
+// returning a nil node (and no error) if the token normalizes away to
+// nothing, as happens for a bare stopword.
+func webSearchTermNode(cfg Config, tok websearchToken) (*tsNode, error) {
+	if tok.quoted {
+		q, err := PhraseToTSQuery(cfg, tok.text)
+		if err != nil {
+			return nil, err
+		}
+		return q.root, nil
+	}
+	v, err := ToTSVector(cfg, tok.text)
+	if err != nil {
+		return nil, err
+	}
+	if len(v) == 0 {
+		return nil, nil
+	}
+	return &tsNode{op: invalid, term: tsTerm{lexeme: v[0].lexeme}}, nil
+}
+
+// websearchToken is one operand scanned out of websearch_to_tsquery's input:
+// either a bare word, a "quoted phrase", or the "or" keyword.
+type websearchToken struct {
+	text    string
+	quoted  bool
+	negated bool
+	isOr    bool
+}
+
+// tokenizeWebSearch splits websearch_to_tsquery's input into words, quoted
+// phrases, and "or" keywords, honoring a leading "-" as negation on either a
+// word or a quoted phrase.
+func tokenizeWebSearch(text string) []websearchToken {
+	var tokens []websearchToken
+	runes := []rune(text)
+	i := 0
+	for i < len(runes) {
+		for i < len(runes) && unicode.IsSpace(runes[i]) {
+			i++
+		}
+		if i >= len(runes) {
+			break
+		}
+		negated := false
+		if runes[i] == '-' {
+			negated = true
+			i++
+		}
+		if i < len(runes) && runes[i] == '"' {
+			i++
+			start := i
+			for i < len(runes) && runes[i] != '"' {
+				i++
+			}
+			phrase := string(runes[start:i])
+			if i < len(runes) {
+				i++ // Skip the closing quote.
+			}
+			if strings.TrimSpace(phrase) != "" {
+				tokens = append(tokens, websearchToken{text: phrase, quoted: true, negated: negated})
+			}
+			continue
+		}
+		start := i
+		for i < len(runes) && !unicode.IsSpace(runes[i]) && runes[i] != '"' {
+			i++
+		}
+		word := string(runes[start:i])
+		if word == "" {
+			continue
+		}
+		if !negated && strings.EqualFold(word, "or") {
+			tokens = append(tokens, websearchToken{isOr: true})
+			continue
+		}
+		tokens = append(tokens, websearchToken{text: word, negated: negated})
+	}
+	return tokens
+}
+
+// vectorPosition pairs a lexeme with the position it occupies in a TSVector,
+// for use when the document order of the vector's terms needs to be
+// reconstructed (the vector itself is sorted by lexeme, not by position).
+type vectorPosition struct {
+	position uint16
+	lexeme   string
+}
+
+// flattenVectorPositions returns every (position, lexeme) pair in v, sorted
+// by position so that the vector's original document order is recovered.
+func flattenVectorPositions(v TSVector) []vectorPosition {
+	var entries []vectorPosition
+	for _, term := range v {
+		for _, p := range term.positions {
+			entries = append(entries, vectorPosition{position: p.position, lexeme: term.lexeme})
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].position < entries[j].position })
+	return entries
+}
@@ -0,0 +1,114 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package tsearch
+
+import "testing"
+
+func evalQuery(t *testing.T, q TSQuery, v TSVector) bool {
+	t.Helper()
+	match, err := EvalTSQuery(q, v)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return match
+}
+
+func TestWebSearchToTSQuery(t *testing.T) {
+	both := vec(map[string][]tsPosition{"cat": {{position: 1}}, "dog": {{position: 2}}})
+	catOnly := vec(map[string][]tsPosition{"cat": {{position: 1}}})
+	dogOnly := vec(map[string][]tsPosition{"dog": {{position: 1}}})
+
+	testCases := []struct {
+		name  string
+		text  string
+		v     TSVector
+		match bool
+	}{
+		{name: "bare words are AND-ed", text: "cat dog", v: both, match: true},
+		{name: "bare words require all terms", text: "cat dog", v: catOnly, match: false},
+		{name: "or separates alternatives", text: "cat or dog", v: dogOnly, match: true},
+		{name: "leading dash negates a word", text: "-cat", v: dogOnly, match: true},
+		{name: "leading dash negates a word, no match when present", text: "-cat", v: catOnly, match: false},
+		{
+			name:  "quoted phrase requires adjacency",
+			text:  `"cat dog"`,
+			v:     both,
+			match: true,
+		},
+		{
+			name:  "quoted phrase doesn't match non-adjacent terms",
+			text:  `"cat dog"`,
+			v:     vec(map[string][]tsPosition{"cat": {{position: 1}}, "dog": {{position: 5}}}),
+			match: false,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			q, err := WebSearchToTSQuery(Config{}, tc.text)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if match := evalQuery(t, q, tc.v); match != tc.match {
+				t.Errorf("expected match=%v, got %v", tc.match, match)
+			}
+		})
+	}
+}
+
+func TestWebSearchToTSQueryEmptyInput(t *testing.T) {
+	q, err := WebSearchToTSQuery(Config{}, "   ")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if q.root != nil {
+		t.Errorf("expected an empty query from input with no usable terms")
+	}
+}
+
+func TestPhraseToTSQuery(t *testing.T) {
+	testCases := []struct {
+		name  string
+		v     TSVector
+		match bool
+	}{
+		{
+			name:  "adjacent lexemes match",
+			v:     vec(map[string][]tsPosition{"cat": {{position: 1}}, "dog": {{position: 2}}}),
+			match: true,
+		},
+		{
+			name:  "non-adjacent lexemes don't match",
+			v:     vec(map[string][]tsPosition{"cat": {{position: 1}}, "dog": {{position: 3}}}),
+			match: false,
+		},
+	}
+	q, err := PhraseToTSQuery(Config{}, "cat dog")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if match := evalQuery(t, q, tc.v); match != tc.match {
+				t.Errorf("expected match=%v, got %v", tc.match, match)
+			}
+		})
+	}
+}
+
+func TestPhraseToTSQueryEmptyInput(t *testing.T) {
+	q, err := PhraseToTSQuery(Config{}, "   ")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if q.root != nil {
+		t.Errorf("expected an empty query from input with no usable terms")
+	}
+}